@@ -0,0 +1,49 @@
+package sse
+
+import "encoding/json"
+
+// Broker lets Broadcast fan a message out across multiple instances of an
+// application, so that a message published on one instance also reaches
+// clients connected to any other instance subscribed to the same topic.
+// Without a Broker, SSE fan-out is local to a single process, which makes
+// it unusable behind a load balancer that doesn't pin clients to an instance.
+type Broker interface {
+	// Publish sends msg to every instance subscribed to topic
+	Publish(topic string, msg *Message) error
+	// Subscribe registers handler to be called whenever any instance
+	// publishes to topic. The returned func cancels the subscription
+	Subscribe(topic string, handler func(*Message)) (unsubscribe func(), err error)
+}
+
+// MessageCodec (de)serializes a Message for transport over a Broker
+type MessageCodec interface {
+	Encode(*Message) ([]byte, error)
+	Decode([]byte) (*Message, error)
+}
+
+// jsonCodec is the default MessageCodec
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(msg *Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte) (*Message, error) {
+	msg := &Message{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// noopBroker is the Broker used when none is configured with WithBroker,
+// keeping fan-out entirely in-process
+type noopBroker struct{}
+
+func (noopBroker) Publish(topic string, msg *Message) error {
+	return nil
+}
+
+func (noopBroker) Subscribe(topic string, handler func(*Message)) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,47 @@
+package sse
+
+// NATSConn is the subset of *nats.Conn (github.com/nats-io/nats.go) that
+// NATSBroker needs. Accepting an interface instead of the concrete type
+// keeps this package free of a hard dependency on the NATS client
+type NATSConn interface {
+	Publish(subj string, data []byte) error
+	Subscribe(subj string, cb func(data []byte)) (unsubscribe func() error, err error)
+}
+
+// NATSBroker is a Broker backed by a NATS connection
+type NATSBroker struct {
+	Conn  NATSConn
+	Codec MessageCodec
+}
+
+// NewNATSBroker returns a *NATSBroker using conn, encoding messages as JSON
+func NewNATSBroker(conn NATSConn) *NATSBroker {
+	return &NATSBroker{
+		Conn:  conn,
+		Codec: jsonCodec{},
+	}
+}
+
+func (nb *NATSBroker) Publish(topic string, msg *Message) error {
+	data, err := nb.Codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return nb.Conn.Publish(topic, data)
+}
+
+func (nb *NATSBroker) Subscribe(topic string, handler func(*Message)) (func(), error) {
+	cancel, err := nb.Conn.Subscribe(topic, func(data []byte) {
+		msg, err := nb.Codec.Decode(data)
+		if err != nil {
+			return
+		}
+		handler(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _ = cancel() }, nil
+}
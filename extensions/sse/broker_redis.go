@@ -0,0 +1,48 @@
+package sse
+
+// RedisPubSub is the subset of a Redis Pub/Sub client (e.g.
+// github.com/redis/go-redis) that RedisBroker needs. Accepting an interface
+// instead of a concrete client keeps this package free of a hard dependency
+// on a Redis client
+type RedisPubSub interface {
+	Publish(channel string, data []byte) error
+	Subscribe(channel string, handler func(data []byte)) (unsubscribe func() error, err error)
+}
+
+// RedisBroker is a Broker backed by Redis Pub/Sub
+type RedisBroker struct {
+	Client RedisPubSub
+	Codec  MessageCodec
+}
+
+// NewRedisBroker returns a *RedisBroker using client, encoding messages as JSON
+func NewRedisBroker(client RedisPubSub) *RedisBroker {
+	return &RedisBroker{
+		Client: client,
+		Codec:  jsonCodec{},
+	}
+}
+
+func (rb *RedisBroker) Publish(topic string, msg *Message) error {
+	data, err := rb.Codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return rb.Client.Publish(topic, data)
+}
+
+func (rb *RedisBroker) Subscribe(topic string, handler func(*Message)) (func(), error) {
+	cancel, err := rb.Client.Subscribe(topic, func(data []byte) {
+		msg, err := rb.Codec.Decode(data)
+		if err != nil {
+			return
+		}
+		handler(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { _ = cancel() }, nil
+}
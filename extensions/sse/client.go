@@ -2,12 +2,20 @@ package sse
 
 import (
 	"context"
-	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ClientManager interface {
-	// New should return a new client, and the total number of active clients after adding this new one
-	New(ctx context.Context, w http.ResponseWriter, clientID string) (*Client, int)
+	// New should return a new client, and the total number of active clients
+	// after adding this new one. lastEventID is the client's Last-Event-ID
+	// (0 if absent/unknown), used to replay missed messages on Subscribe
+	// when ReplayOnConnect is enabled
+	New(ctx context.Context, transport Transport, clientID string, lastEventID uint64) (*Client, int)
 	// Range should iterate through all the active clients
 	Range(func(*Client))
 	// Remove should remove the active client given a clientID, and close the connection
@@ -18,13 +26,30 @@ type ClientManager interface {
 	Clients() []*Client
 	// Client returns *Client if clientID is active
 	Client(clientID string) *Client
+	// Metrics returns a snapshot of this manager's counters
+	Metrics() Metrics
+
+	// Subscribe adds clientID as a subscriber of topic, and replays any
+	// messages it missed since its Last-Event-ID if ReplayOnConnect is enabled
+	Subscribe(clientID, topic string)
+	// Unsubscribe removes clientID from topic's subscribers
+	Unsubscribe(clientID, topic string)
+	// Topics returns the list of topics clientID is subscribed to
+	Topics(clientID string) []string
+	// Broadcast sends msg to every client subscribed to topic. A slow subscriber
+	// blocks it for at most WriteTimeout (not at all when none is configured).
+	// It returns the number of clients the message was delivered to
+	// and the number it was dropped for
+	Broadcast(topic string, msg *Message) (delivered int, dropped int)
+	// RangeTopic iterates through all the clients subscribed to topic
+	RangeTopic(topic string, f func(*Client))
 }
 
 type Client struct {
-	ID             string
-	Msg            chan *Message
-	ResponseWriter http.ResponseWriter
-	Ctx            context.Context
+	ID        string
+	Msg       chan *Message
+	Transport Transport
+	Ctx       context.Context
 }
 
 type eventType int
@@ -35,6 +60,11 @@ const (
 	eTypeRemoveClient
 	eTypeActiveClientCount
 	eTypeClient
+	eTypeSubscribe
+	eTypeUnsubscribe
+	eTypeTopics
+	eTypeTopicClientList
+	eTypeBroadcast
 )
 
 func (et eventType) String() string {
@@ -47,25 +77,161 @@ func (et eventType) String() string {
 		return "remove_client"
 	case eTypeActiveClientCount:
 		return "active_client_count"
+	case eTypeSubscribe:
+		return "subscribe"
+	case eTypeUnsubscribe:
+		return "unsubscribe"
+	case eTypeTopics:
+		return "topics"
+	case eTypeTopicClientList:
+		return "topic_client_list"
+	case eTypeBroadcast:
+		return "broadcast"
 	}
 	return "unknown"
 }
 
 type event struct {
-	Type     eventType
-	ClientID string
-	Client   *Client
-	Response chan *eventResponse
+	Type        eventType
+	ClientID    string
+	Client      *Client
+	Cancel      context.CancelFunc
+	LastEventID uint64
+	Topic       string
+	Message     *Message
+	Response    chan *eventResponse
 }
 type eventResponse struct {
 	Clients          []*Client
 	RemainingClients int
 	Client           *Client
+	Topics           []string
+	// Backlog is eTypeSubscribe's reply: every message stored for the topic
+	// since the client's Last-Event-ID, captured in the same listener step
+	// that adds its topic membership - see Subscribe
+	Backlog []*Message
 }
 type Clients struct {
 	clients   map[string]*Client
 	MsgBuffer int
 	events    chan<- event
+
+	// topics maps a topic to the set of clientIDs subscribed to it
+	topics map[string]map[string]struct{}
+	// cancels holds the cancel func derived for each client's context, so
+	// Remove can cancel it and unblock whatever is streaming to that client
+	cancels map[string]context.CancelFunc
+	// lastEventIDs holds the Last-Event-ID each client connected with, so
+	// Subscribe knows where to resume replay from
+	lastEventIDs map[string]uint64
+
+	// broker fans Broadcast out to other instances, and re-fans their
+	// broadcasts into this instance's local clients
+	broker Broker
+	// brokerSubs tracks the active broker subscription per topic, so each
+	// instance subscribes to a given topic only once
+	brokerMu   sync.Mutex
+	brokerSubs map[string]func()
+	// instanceID stamps every message this instance publishes via broker, so
+	// its own broker subscription handler can recognise and ignore a Broker
+	// echoing that same message back to it
+	instanceID string
+	// idNamespace occupies the high 32 bits of every message ID this
+	// instance assigns, derived from instanceID. Without it, two instances
+	// sharing a Broker would each hand out IDs from their own seq starting
+	// at 1, so a broker-echoed message and a locally-originated one can
+	// collide once both land in the same local EventStore
+	idNamespace uint64
+	// idSeq is the low 32 bits counter backing this instance's namespaced IDs
+	idSeq uint64
+
+	// PingInterval, if non-zero, makes every client receive a transport
+	// level ping (an SSE comment line, or a WebSocket ping frame) on this
+	// interval, so idle connections are kept alive and dead ones detected
+	PingInterval time.Duration
+	// WriteTimeout, if non-zero, bounds how long a send to a client's Msg
+	// channel may block. A client that doesn't drain its channel within
+	// WriteTimeout is marked slow and evicted, instead of wedging broadcasts
+	WriteTimeout time.Duration
+
+	// store is where Broadcast appends published messages and Subscribe
+	// replays them from, keyed by topic
+	store EventStore
+	// ReplayOnConnect, if true, makes Subscribe stream every message stored
+	// for the topic with an ID greater than the client's Last-Event-ID
+	// before returning, so a reconnecting client catches up before
+	// rejoining live broadcast
+	ReplayOnConnect bool
+
+	dropped      int64
+	messagesSent int64
+	slowClients  int64
+}
+
+// Metrics is a snapshot of a Clients instance's counters
+type Metrics struct {
+	Active       int
+	Dropped      int64
+	MessagesSent int64
+	SlowClients  int64
+}
+
+// Option configures a Clients instance created by NewClientManager
+type Option func(*Clients)
+
+// WithBroker configures the Broker used to fan Broadcast out across
+// multiple instances of an application. Without one, SSE fan-out stays
+// local to this process
+func WithBroker(broker Broker) Option {
+	return func(cs *Clients) {
+		cs.broker = broker
+	}
+}
+
+// WithPingInterval configures how often clients are sent a transport-level
+// ping. Disabled (the default) when interval is 0
+func WithPingInterval(interval time.Duration) Option {
+	return func(cs *Clients) {
+		cs.PingInterval = interval
+	}
+}
+
+// WithWriteTimeout configures how long a send to a client's Msg channel may
+// block before that client is considered slow and evicted. Sends never
+// block (and instead drop immediately) when timeout is 0, the default
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(cs *Clients) {
+		cs.WriteTimeout = timeout
+	}
+}
+
+// WithEventStore configures the EventStore Broadcast appends published
+// messages to and Subscribe replays them from. Without one, messages are
+// still assigned monotonic IDs but nothing is retained, so replay is a no-op
+func WithEventStore(store EventStore) Option {
+	return func(cs *Clients) {
+		cs.store = store
+	}
+}
+
+// WithMsgBuffer configures the buffer size of each client's Msg channel.
+// Defaults to 10. With WithReplayOnConnect enabled, replay streams a
+// client's missed backlog into this same channel before Subscribe returns,
+// so sizing it too small for the expected backlog (relative to the
+// configured EventStore's retention) will silently drop the oldest of it
+func WithMsgBuffer(size int) Option {
+	return func(cs *Clients) {
+		cs.MsgBuffer = size
+	}
+}
+
+// WithReplayOnConnect enables replaying a client's missed messages, from its
+// Last-Event-ID, on every topic it subscribes to. Requires WithEventStore to
+// have anything to replay
+func WithReplayOnConnect(enabled bool) Option {
+	return func(cs *Clients) {
+		cs.ReplayOnConnect = enabled
+	}
 }
 
 func (cs *Clients) listener(events <-chan event) {
@@ -73,6 +239,11 @@ func (cs *Clients) listener(events <-chan event) {
 		switch ev.Type {
 		case eTypeNewClient:
 			cs.clients[ev.Client.ID] = ev.Client
+			cs.cancels[ev.Client.ID] = ev.Cancel
+			cs.lastEventIDs[ev.Client.ID] = ev.LastEventID
+			ev.Response <- &eventResponse{
+				RemainingClients: len(cs.clients),
+			}
 
 		case eTypeClientList:
 			copied := make([]*Client, 0, len(cs.clients))
@@ -84,40 +255,176 @@ func (cs *Clients) listener(events <-chan event) {
 			}
 
 		case eTypeRemoveClient:
+			cli := cs.clients[ev.ClientID]
+			if cli != nil {
+				// cancelling Ctx is what unblocks/closes its streaming
+				// handler; closing Transport too is what unblocks a
+				// goroutine stuck reading from the underlying connection
+				// (ReadPump's conn.ReadMessage), which Ctx being cancelled
+				// doesn't by itself
+				_ = cli.Transport.Close()
+				if cancel := cs.cancels[ev.ClientID]; cancel != nil {
+					cancel()
+				}
+				delete(cs.cancels, ev.ClientID)
+				delete(cs.lastEventIDs, ev.ClientID)
+				delete(cs.clients, ev.ClientID)
+				for topic, members := range cs.topics {
+					delete(members, ev.ClientID)
+					if len(members) == 0 {
+						delete(cs.topics, topic)
+						cs.teardownBrokerSubscription(topic)
+					}
+				}
+			}
+			ev.Response <- &eventResponse{
+				RemainingClients: len(cs.clients),
+			}
+
+		case eTypeClient:
+			ev.Response <- &eventResponse{
+				Client: cs.clients[ev.ClientID],
+			}
+
+		case eTypeActiveClientCount:
+			ev.Response <- &eventResponse{
+				RemainingClients: len(cs.clients),
+			}
+
+		case eTypeSubscribe:
 			cli := cs.clients[ev.ClientID]
 			if cli == nil {
-				ev.Response <- nil
+				if ev.Response != nil {
+					ev.Response <- nil
+				}
 				continue
 			}
+			members := cs.topics[ev.Topic]
+			if members == nil {
+				members = make(map[string]struct{})
+				cs.topics[ev.Topic] = members
+			}
+			members[ev.ClientID] = struct{}{}
+
+			// Captured in the same step that makes the subscription live, so
+			// a message Broadcast appends after this point is delivered to
+			// cli exactly once: either here (already in the store) or live
+			// via RangeTopic (which will now see cli as a member), never both
+			var backlog []*Message
+			if cs.ReplayOnConnect {
+				backlog = cs.store.Since(ev.Topic, cs.lastEventIDs[ev.ClientID])
+			}
+			if ev.Response != nil {
+				ev.Response <- &eventResponse{
+					Client:  cli,
+					Backlog: backlog,
+				}
+			}
 
-			// Ctx.Done() is needed to close its streaming handler
-			cli.Ctx.Done()
-			delete(cs.clients, ev.ClientID)
-			ev.Response <- nil
+		case eTypeUnsubscribe:
+			members := cs.topics[ev.Topic]
+			if members == nil {
+				continue
+			}
+			delete(members, ev.ClientID)
+			if len(members) == 0 {
+				delete(cs.topics, ev.Topic)
+				cs.teardownBrokerSubscription(ev.Topic)
+			}
 
-		case eTypeClient:
+		case eTypeTopics:
+			topics := make([]string, 0, len(cs.topics))
+			for topic, members := range cs.topics {
+				if _, ok := members[ev.ClientID]; ok {
+					topics = append(topics, topic)
+				}
+			}
 			ev.Response <- &eventResponse{
-				Client: cs.clients[ev.ClientID],
+				Topics: topics,
+			}
+
+		case eTypeTopicClientList:
+			members := cs.topics[ev.Topic]
+			copied := make([]*Client, 0, len(members))
+			for clientID := range members {
+				if cli := cs.clients[clientID]; cli != nil {
+					copied = append(copied, cli)
+				}
+			}
+			ev.Response <- &eventResponse{
+				Clients: copied,
+			}
+
+		case eTypeBroadcast:
+			cs.store.Append(ev.Topic, ev.Message)
+
+			// Snapshotting topic's members in the same step that appends
+			// ev.Message is what makes Subscribe's backlog-vs-live split
+			// exactly-once: whichever side of this step a given Subscribe
+			// call's own listener step falls on, it either already sees
+			// ev.Message in the store (replay) or already sees cli as a
+			// member here (live), never both. Appending first and
+			// snapshotting members from the caller's goroutine instead
+			// would leave a window between the two where that isn't true
+			members := cs.topics[ev.Topic]
+			copied := make([]*Client, 0, len(members))
+			for clientID := range members {
+				if cli := cs.clients[clientID]; cli != nil {
+					copied = append(copied, cli)
+				}
+			}
+			ev.Response <- &eventResponse{
+				Clients: copied,
 			}
 		}
 	}
 }
 
-func (cs *Clients) New(ctx context.Context, w http.ResponseWriter, clientID string) (*Client, int) {
+func (cs *Clients) New(ctx context.Context, transport Transport, clientID string, lastEventID uint64) (*Client, int) {
+	cctx, cancel := context.WithCancel(ctx)
 	mchan := make(chan *Message, cs.MsgBuffer)
 	cli := &Client{
-		ID:             clientID,
-		Msg:            mchan,
-		ResponseWriter: w,
-		Ctx:            ctx,
+		ID:        clientID,
+		Msg:       mchan,
+		Transport: transport,
+		Ctx:       cctx,
 	}
 
+	rch := make(chan *eventResponse)
 	cs.events <- event{
-		Type:   eTypeNewClient,
-		Client: cli,
+		Type:        eTypeNewClient,
+		Client:      cli,
+		Cancel:      cancel,
+		LastEventID: lastEventID,
+		Response:    rch,
+	}
+	response := <-rch
+
+	if cs.PingInterval > 0 {
+		go cs.pingLoop(cli)
 	}
 
-	return cli, len(cs.clients)
+	return cli, response.RemainingClients
+}
+
+// pingLoop queues a ping sentinel on cli.Msg every PingInterval. It never
+// writes to cli.Transport itself: whatever goroutine drains cli.Msg and
+// calls Transport.Send for real messages (the SSE handler's own loop, or
+// websocket.WritePump) is the connection's single writer, and a ping has to
+// go through that same serialized path rather than racing it from here
+func (cs *Clients) pingLoop(cli *Client) {
+	ticker := time.NewTicker(cs.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cli.Ctx.Done():
+			return
+
+		case <-ticker.C:
+			cs.send(cli, newPingMessage())
+		}
+	}
 }
 
 func (cs *Clients) Range(f func(cli *Client)) {
@@ -142,14 +449,62 @@ func (cs *Clients) Remove(clientID string) int {
 		Response: rch,
 	}
 
-	<-rch
-
-	return len(cs.clients)
+	response := <-rch
+	return response.RemainingClients
 }
 
+// Active returns the number of active clients, asking the listener
+// goroutine rather than reading cs.clients directly since that map is only
+// ever safe to read/write from within listener
 func (cs *Clients) Active() int {
-	return len(cs.clients)
+	rch := make(chan *eventResponse)
+	cs.events <- event{
+		Type:     eTypeActiveClientCount,
+		Response: rch,
+	}
+
+	response := <-rch
+	return response.RemainingClients
+}
+
+// Metrics returns a snapshot of this manager's counters
+func (cs *Clients) Metrics() Metrics {
+	return Metrics{
+		Active:       cs.Active(),
+		Dropped:      atomic.LoadInt64(&cs.dropped),
+		MessagesSent: atomic.LoadInt64(&cs.messagesSent),
+		SlowClients:  atomic.LoadInt64(&cs.slowClients),
+	}
+}
+
+// send delivers msg to cli.Msg. With no WriteTimeout configured it never
+// blocks, dropping msg immediately if the client's buffer is full. With a
+// WriteTimeout, a send that blocks past the deadline marks cli slow,
+// evicts it, and counts it as dropped
+func (cs *Clients) send(cli *Client, msg *Message) bool {
+	if cs.WriteTimeout <= 0 {
+		select {
+		case cli.Msg <- msg:
+			atomic.AddInt64(&cs.messagesSent, 1)
+			return true
+		default:
+			atomic.AddInt64(&cs.dropped, 1)
+			return false
+		}
+	}
 
+	timer := time.NewTimer(cs.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case cli.Msg <- msg:
+		atomic.AddInt64(&cs.messagesSent, 1)
+		return true
+	case <-timer.C:
+		atomic.AddInt64(&cs.slowClients, 1)
+		atomic.AddInt64(&cs.dropped, 1)
+		cs.Remove(cli.ID)
+		return false
+	}
 }
 
 // MessageChannels returns a slice of message channels of all clients
@@ -168,21 +523,60 @@ func (cs *Clients) Clients() []*Client {
 func (cs *Clients) Client(clientID string) *Client {
 	rch := make(chan *eventResponse)
 	cs.events <- event{
-		Type:     eTypeClientList,
+		Type:     eTypeClient,
+		ClientID: clientID,
 		Response: rch,
 	}
 	cli := <-rch
 	return cli.Client
 }
 
-func NewClientManager() ClientManager {
+func NewClientManager(opts ...Option) ClientManager {
 	const buffer = 10
 	events := make(chan event, buffer)
+	instanceID := newInstanceID()
 	cli := &Clients{
-		clients:   make(map[string]*Client),
-		events:    events,
-		MsgBuffer: buffer,
+		clients:      make(map[string]*Client),
+		events:       events,
+		MsgBuffer:    buffer,
+		topics:       make(map[string]map[string]struct{}),
+		cancels:      make(map[string]context.CancelFunc),
+		lastEventIDs: make(map[string]uint64),
+		broker:       noopBroker{},
+		brokerSubs:   make(map[string]func()),
+		instanceID:   instanceID,
+		idNamespace:  newIDNamespace(instanceID),
+		store:        &noopEventStore{},
+	}
+
+	for _, opt := range opts {
+		opt(cli)
 	}
 	go cli.listener(events)
 	return cli
 }
+
+// newInstanceID returns a random identifier unique enough to tell this
+// process's Clients instance apart from any other instance sharing a Broker
+func newInstanceID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// newIDNamespace derives the namespace Broadcast stamps into the high 32
+// bits of every message ID this instance assigns, from instanceID
+func newIDNamespace(instanceID string) uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(instanceID))
+	return uint64(h.Sum32()) << 32
+}
+
+// nextMessageID returns the next ID this instance should stamp on a message
+// it originates. The namespace in the high bits keeps it from colliding
+// with an ID a different instance assigned for its own message, even after
+// a Broker echoes both into the same local EventStore; see Broadcast
+func (cs *Clients) nextMessageID() uint64 {
+	seq := atomic.AddUint64(&cs.idSeq, 1)
+	return cs.idNamespace | (seq & 0xffffffff)
+}
@@ -0,0 +1,58 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeTransport is a Transport that just records what it was sent, for use
+// in tests that don't need a real connection
+type fakeTransport struct {
+	mu   sync.Mutex
+	sent []*Message
+}
+
+func (t *fakeTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func (t *fakeTransport) Ping() error { return nil }
+
+func (t *fakeTransport) Close() error { return nil }
+
+// TestClientsConcurrentAccess calls New, Active and Remove concurrently from
+// many goroutines, the same mix of traffic WriteTimeout eviction and a
+// polled Metrics/Active produce in practice. Run with -race: before
+// RemainingClients was threaded through listener's response, New, Remove
+// and Active read cs.clients directly from the caller's goroutine while
+// listener mutated it concurrently, and this reliably reported a
+// "concurrent map read and map write"
+func TestClientsConcurrentAccess(t *testing.T) {
+	cm := NewClientManager()
+
+	var wg sync.WaitGroup
+	const n = 50
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cli, _ := cm.New(ctx, &fakeTransport{}, fmt.Sprintf("client-%d", i), 0)
+			_ = cm.Active()
+			cm.Remove(cli.ID)
+		}(i)
+	}
+	wg.Wait()
+
+	if active := cm.Active(); active != 0 {
+		t.Fatalf("expected 0 active clients once all goroutines finished, got %d", active)
+	}
+}
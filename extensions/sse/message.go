@@ -0,0 +1,39 @@
+package sse
+
+// Message represents a single SSE event sent to one or more clients
+type Message struct {
+	// ID is sent as the event's id field, and used for Last-Event-ID replay.
+	// Broadcast assigns it when left at 0, namespaced to the assigning
+	// instance - see Clients.nextMessageID
+	ID uint64
+
+	Event string
+	Retry uint
+	Data  interface{}
+
+	// Origin identifies the Clients instance msg was published from. It is
+	// stamped by Broadcast and used to ignore a message a Broker echoes
+	// back to the instance that published it, rather than re-delivering it
+	// to that instance's own local clients a second time
+	Origin string
+
+	// ping marks msg as a transport-level keep-alive rather than
+	// application data. It travels through cli.Msg like any other message,
+	// so Transport.Send - the single writer for that client's connection -
+	// is the one place that ever calls Transport.Ping, instead of
+	// Clients.pingLoop calling it from a second goroutine
+	ping bool
+}
+
+// newPingMessage returns the sentinel Message pingLoop sends down cli.Msg.
+// A Transport.Send implementation must check IsPing and call its own Ping
+// instead of writing msg as application data
+func newPingMessage() *Message {
+	return &Message{ping: true}
+}
+
+// IsPing reports whether msg is the keep-alive sentinel pingLoop sends down
+// cli.Msg, rather than an application message
+func (m *Message) IsPing() bool {
+	return m.ping
+}
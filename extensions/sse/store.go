@@ -0,0 +1,130 @@
+package sse
+
+import (
+	"sync"
+	"time"
+)
+
+// EventStore persists published messages per topic so a reconnecting client
+// can replay whatever it missed since its Last-Event-ID instead of silently
+// losing it. Implementations must be safe for concurrent use. MemoryEventStore
+// is the bundled default; a durable store (backed by e.g. Postgres or Redis
+// streams) can be plugged in via WithEventStore the same way a Broker is.
+//
+// Append is normally never asked to assign an ID when Clients is involved:
+// Broadcast stamps msg.ID itself, namespaced per instance, before calling
+// Append. That keeps IDs from colliding across instances sharing a Broker,
+// but each instance's bundled store is still local - see Broadcast's doc
+// comment for what that does and doesn't guarantee about replay ordering
+// across instances
+type EventStore interface {
+	// Append stores msg under topic, assigning msg.ID if it is unset, and
+	// returns the ID it was stored under
+	Append(topic string, msg *Message) uint64
+	// Since returns every message stored for topic with ID > lastID, oldest first
+	Since(topic string, lastID uint64) []*Message
+}
+
+// noopEventStore is the EventStore used when none is configured with
+// WithEventStore. It still assigns monotonic IDs, but keeps nothing, so
+// Since is always empty and replay is a no-op
+type noopEventStore struct {
+	mu  sync.Mutex
+	seq uint64
+}
+
+func (s *noopEventStore) Append(topic string, msg *Message) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.ID == 0 {
+		s.seq++
+		msg.ID = s.seq
+	}
+	return msg.ID
+}
+
+func (s *noopEventStore) Since(topic string, lastID uint64) []*Message {
+	return nil
+}
+
+// MemoryEventStore is an in-memory, per-topic ring buffer EventStore. Its
+// history is bounded by MaxSize (a count) and/or MaxAge; either left at 0
+// disables that bound, but leaving both at 0 makes it grow without limit
+type MemoryEventStore struct {
+	MaxSize int
+	MaxAge  time.Duration
+
+	mu     sync.Mutex
+	seq    uint64
+	topics map[string][]storedMessage
+}
+
+type storedMessage struct {
+	msg      *Message
+	storedAt time.Time
+}
+
+// NewMemoryEventStore returns a *MemoryEventStore that retains, per topic,
+// at most maxSize messages no older than maxAge. Both bounds are applied on
+// each Append to a topic, so a message past maxAge is kept, and returned by
+// Since, until the next Append to its topic. Pass 0 for either to disable
+// that particular bound
+func NewMemoryEventStore(maxSize int, maxAge time.Duration) *MemoryEventStore {
+	return &MemoryEventStore{
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+		topics:  make(map[string][]storedMessage),
+	}
+}
+
+func (s *MemoryEventStore) Append(topic string, msg *Message) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.ID == 0 {
+		s.seq++
+		msg.ID = s.seq
+	} else if msg.ID > s.seq {
+		s.seq = msg.ID
+	}
+
+	entries := append(s.topics[topic], storedMessage{msg: msg, storedAt: time.Now()})
+	s.topics[topic] = s.evict(entries)
+
+	return msg.ID
+}
+
+// evict drops entries older than MaxAge and, if there are still more than
+// MaxSize left, the oldest of the remainder
+func (s *MemoryEventStore) evict(entries []storedMessage) []storedMessage {
+	if s.MaxAge > 0 {
+		cutoff := time.Now().Add(-s.MaxAge)
+		i := 0
+		for i < len(entries) && entries[i].storedAt.Before(cutoff) {
+			i++
+		}
+		entries = entries[i:]
+	}
+
+	if s.MaxSize > 0 && len(entries) > s.MaxSize {
+		entries = entries[len(entries)-s.MaxSize:]
+	}
+
+	return entries
+}
+
+func (s *MemoryEventStore) Since(topic string, lastID uint64) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.topics[topic]
+	out := make([]*Message, 0, len(entries))
+	for _, entry := range entries {
+		if entry.msg.ID > lastID {
+			out = append(out, entry.msg)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,198 @@
+package sse
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Subscribe adds clientID as a subscriber of topic. It is a no-op if clientID
+// is not an active client. If ReplayOnConnect is enabled, it then streams
+// every message stored for topic since clientID's Last-Event-ID, so a
+// reconnecting client catches up before joining live broadcast.
+//
+// The backlog is fetched from the listener goroutine in the same step that
+// adds clientID's topic membership. Broadcast appends a message and
+// snapshots topic's members in that same single listener step too (see
+// eTypeBroadcast), so whichever side of this Subscribe call's step a given
+// broadcast falls on, it is either already in the store (replayed here) or
+// the snapshot already includes clientID (delivered live), never both. It
+// runs before Subscribe returns, i.e. before the client's Msg channel has a
+// reader yet, so a backlog larger than MsgBuffer is silently truncated to
+// its most recent entries - size MsgBuffer (via WithMsgBuffer) for the
+// backlog a reconnecting client is expected to have missed
+func (cs *Clients) Subscribe(clientID, topic string) {
+	rch := make(chan *eventResponse)
+	cs.events <- event{
+		Type:     eTypeSubscribe,
+		ClientID: clientID,
+		Topic:    topic,
+		Response: rch,
+	}
+	response := <-rch
+	if response == nil {
+		return
+	}
+
+	cs.ensureBrokerSubscription(topic)
+
+	// keep only the most recent entries that fit in the client's free buffer
+	backlog := response.Backlog
+	if excess := len(backlog) - (cap(response.Client.Msg) - len(response.Client.Msg)); excess > 0 {
+		backlog = backlog[excess:]
+	}
+	for _, msg := range backlog {
+		cs.send(response.Client, msg)
+	}
+}
+
+// ensureBrokerSubscription subscribes to topic on cs.broker the first time
+// it is needed, so that messages broadcast by other instances get re-fanned
+// out to this instance's local clients
+func (cs *Clients) ensureBrokerSubscription(topic string) {
+	cs.brokerMu.Lock()
+	defer cs.brokerMu.Unlock()
+
+	if _, ok := cs.brokerSubs[topic]; ok {
+		return
+	}
+
+	cancel, err := cs.broker.Subscribe(topic, func(msg *Message) {
+		// Brokers commonly echo a publish back to the instance that sent
+		// it if that instance is also a subscriber; Broadcast already
+		// delivered msg to this instance's local clients, so skip it here
+		if msg.Origin == cs.instanceID {
+			return
+		}
+
+		// This instance never saw msg go through its own Broadcast, so it
+		// must append it to its own EventStore here (via appendAndDeliver)
+		// to be able to replay it to a client that reconnects to this
+		// instance later
+		cs.appendAndDeliver(topic, msg)
+	})
+	if err != nil {
+		return
+	}
+
+	cs.brokerSubs[topic] = cancel
+}
+
+// teardownBrokerSubscription cancels topic's broker subscription once
+// listener has deleted its last local subscriber, so an idle topic doesn't
+// keep a NATS/Redis-level subscription (and the goroutine behind it) alive
+// for the rest of the process's life. ensureBrokerSubscription re-subscribes
+// the next time a client subscribes to topic
+func (cs *Clients) teardownBrokerSubscription(topic string) {
+	cs.brokerMu.Lock()
+	defer cs.brokerMu.Unlock()
+
+	cancel, ok := cs.brokerSubs[topic]
+	if !ok {
+		return
+	}
+	cancel()
+	delete(cs.brokerSubs, topic)
+}
+
+// Unsubscribe removes clientID from topic's subscribers
+func (cs *Clients) Unsubscribe(clientID, topic string) {
+	cs.events <- event{
+		Type:     eTypeUnsubscribe,
+		ClientID: clientID,
+		Topic:    topic,
+	}
+}
+
+// Topics returns the list of topics clientID is subscribed to
+func (cs *Clients) Topics(clientID string) []string {
+	rch := make(chan *eventResponse)
+	cs.events <- event{
+		Type:     eTypeTopics,
+		ClientID: clientID,
+		Response: rch,
+	}
+
+	response := <-rch
+	return response.Topics
+}
+
+// RangeTopic iterates through all the clients subscribed to topic
+func (cs *Clients) RangeTopic(topic string, f func(cli *Client)) {
+	rch := make(chan *eventResponse)
+	cs.events <- event{
+		Type:     eTypeTopicClientList,
+		Topic:    topic,
+		Response: rch,
+	}
+
+	response := <-rch
+	for i := range response.Clients {
+		f(response.Clients[i])
+	}
+}
+
+// Broadcast assigns msg an ID (if it doesn't already have one), namespaced
+// to this instance so it can't collide with an ID a different instance
+// assigned - see Clients.nextMessageID. It then appends msg to the
+// configured EventStore, publishes it to topic via the configured Broker (a
+// no-op unless one is set with WithBroker), and sends it to every locally
+// connected client subscribed to topic. Without a WriteTimeout, sends are
+// non-blocking and a subscriber whose Msg channel is full is counted as
+// dropped rather than stalling the broadcast; with one, a subscriber that
+// doesn't drain its channel within the timeout is evicted as a slow client
+//
+// With a Broker wiring multiple instances together, IDs are guaranteed
+// unique but not globally ordered: they only increase monotonically within
+// the instance that assigned them. A client that reconnects to a different
+// instance than the one it last streamed from may see Since(topic, lastID)
+// return messages out of real-world order, or (if that instance's own
+// namespace happens to sort below lastID) skip some of its own messages
+// entirely. Sticky routing back to the originating instance, or a shared
+// EventStore with a coordinated sequence, is required for exact replay
+// ordering across instances
+func (cs *Clients) Broadcast(topic string, msg *Message) (delivered int, dropped int) {
+	if msg.ID == 0 {
+		msg.ID = cs.nextMessageID()
+	}
+	msg.Origin = cs.instanceID
+	delivered, dropped = cs.appendAndDeliver(topic, msg)
+	_ = cs.broker.Publish(topic, msg)
+	return delivered, dropped
+}
+
+// appendAndDeliver appends msg to the configured EventStore and sends it to
+// this instance's clients subscribed to topic, without publishing it to the
+// Broker. The append and the membership snapshot it sends to both happen in
+// the same eTypeBroadcast listener step, which is what makes Subscribe's
+// backlog-vs-live split exactly-once - see Subscribe. Sends themselves
+// happen concurrently, one goroutine per client, so a single slow
+// subscriber (with a WriteTimeout configured) can't delay delivery to the
+// rest, or delay appendAndDeliver's own return, by more than WriteTimeout
+func (cs *Clients) appendAndDeliver(topic string, msg *Message) (delivered int, dropped int) {
+	rch := make(chan *eventResponse)
+	cs.events <- event{
+		Type:     eTypeBroadcast,
+		Topic:    topic,
+		Message:  msg,
+		Response: rch,
+	}
+	response := <-rch
+
+	var wg sync.WaitGroup
+	var deliveredCount, droppedCount int64
+
+	for _, cli := range response.Clients {
+		wg.Add(1)
+		go func(cli *Client) {
+			defer wg.Done()
+			if cs.send(cli, msg) {
+				atomic.AddInt64(&deliveredCount, 1)
+			} else {
+				atomic.AddInt64(&droppedCount, 1)
+			}
+		}(cli)
+	}
+	wg.Wait()
+
+	return int(deliveredCount), int(droppedCount)
+}
@@ -0,0 +1,61 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestSubscribeReplayExactlyOnce races Subscribe against Broadcast on the
+// same topic: before the backlog fetch was moved into the same listener
+// step that adds the topic membership, a message Broadcast appended around
+// subscribe time could reach a client twice - once via the replayed
+// backlog, once via live delivery - because membership became visible to
+// RangeTopic before the backlog snapshot was taken. Run with -race
+func TestSubscribeReplayExactlyOnce(t *testing.T) {
+	const topic = "room"
+	const messagesPerTrial = 20
+
+	for trial := 0; trial < 50; trial++ {
+		cm := NewClientManager(
+			WithEventStore(NewMemoryEventStore(0, 0)),
+			WithReplayOnConnect(true),
+			WithMsgBuffer(messagesPerTrial),
+		).(*Clients)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cli, _ := cm.New(ctx, &fakeTransport{}, fmt.Sprintf("client-%d", trial), 0)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cm.Subscribe(cli.ID, topic)
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < messagesPerTrial; i++ {
+				cm.Broadcast(topic, &Message{Data: i})
+			}
+		}()
+		wg.Wait()
+
+		seen := make(map[uint64]bool)
+	drain:
+		for {
+			select {
+			case msg := <-cli.Msg:
+				if seen[msg.ID] {
+					t.Fatalf("trial %d: message %d delivered more than once", trial, msg.ID)
+				}
+				seen[msg.ID] = true
+			default:
+				break drain
+			}
+		}
+
+		cancel()
+		cm.Remove(cli.ID)
+	}
+}
@@ -0,0 +1,89 @@
+package sse
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Transport sends a Message to a single client over whatever connection it
+// was created with. sse.Client uses httpTransport; the websocket package
+// implements its own Transport over *websocket.Conn
+type Transport interface {
+	// Send must call Ping instead of writing msg as application data when
+	// msg.IsPing() is true, so Clients' PingInterval keep-alives go through
+	// this same single-writer entry point rather than a second one
+	Send(msg *Message) error
+	// Ping writes a transport-level keep-alive, used by Clients' PingInterval
+	// to detect dead connections without sending an actual Message
+	Ping() error
+	Close() error
+}
+
+// httpTransport writes messages in the SSE wire format to an
+// http.ResponseWriter, flushing after every write
+type httpTransport struct {
+	w http.ResponseWriter
+}
+
+// NewHTTPTransport returns the Transport used to stream SSE messages to w.
+// Pass it to Clients.New when serving an SSE (as opposed to WebSocket) client
+func NewHTTPTransport(w http.ResponseWriter) Transport {
+	return &httpTransport{w: w}
+}
+
+func (t *httpTransport) Send(msg *Message) error {
+	if msg.IsPing() {
+		return t.Ping()
+	}
+
+	if msg.ID != 0 {
+		if _, err := fmt.Fprintf(t.w, "id: %d\n", msg.ID); err != nil {
+			return err
+		}
+	}
+	if msg.Event != "" {
+		if _, err := fmt.Fprintf(t.w, "event: %s\n", msg.Event); err != nil {
+			return err
+		}
+	}
+	if msg.Retry != 0 {
+		if _, err := fmt.Fprintf(t.w, "retry: %d\n", msg.Retry); err != nil {
+			return err
+		}
+	}
+	// A multi-line payload needs a "data: " prefix on every line, or the
+	// lines after the first are parsed as separate (empty) SSE fields
+	for _, line := range strings.Split(fmt.Sprintf("%v", msg.Data), "\n") {
+		if _, err := fmt.Fprintf(t.w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(t.w, "\n"); err != nil {
+		return err
+	}
+
+	if f, ok := t.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// Ping writes an SSE comment line, which clients ignore but which keeps the
+// connection from being treated as idle by intermediaries
+func (t *httpTransport) Ping() error {
+	if _, err := fmt.Fprint(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+
+	if f, ok := t.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/naughtygopher/webgo/extensions/sse"
+)
+
+// New registers conn as a new client of manager, using the same
+// sse.ClientManager that SSE clients are registered with so both
+// transports share one set of clients, rooms/topics and broadcasts.
+// lastEventID is the client's Last-Event-ID, if it reconnected with one
+// (0 if absent/unknown)
+func New(ctx context.Context, manager sse.ClientManager, conn Conn, clientID string, lastEventID uint64) (*sse.Client, int) {
+	conn.SetReadLimit(MaxMessageSize)
+	return manager.New(ctx, NewTransport(conn), clientID, lastEventID)
+}
+
+// ReadPump must be run in its own goroutine per connection. It keeps the
+// read deadline alive on every pong, and blocks until conn is closed or
+// errors, at which point it removes clientID from manager and closes conn.
+// This is where a stuck/disconnected WebSocket client is detected, mirroring
+// the gorilla/websocket chat example's read pump
+func ReadPump(manager sse.ClientManager, conn Conn, clientID string) {
+	defer manager.Remove(clientID)
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// WritePump must be run in its own goroutine per connection. It writes
+// every message sent to cli.Msg out over conn, and pings conn every
+// PingPeriod to detect dead connections. It returns once cli.Ctx is
+// cancelled, cli.Msg is closed, or a write fails. This already keeps the
+// connection alive on its own, so there's normally no need to also set
+// sse.WithPingInterval for a manager serving WebSocket clients
+func WritePump(cli *sse.Client, conn Conn) {
+	ticker := time.NewTicker(PingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cli.Ctx.Done():
+			return
+
+		case msg, ok := <-cli.Msg:
+			if !ok {
+				return
+			}
+			if err := cli.Transport.Send(msg); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(pingMessage, nil, time.Now().Add(WriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
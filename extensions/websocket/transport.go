@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/naughtygopher/webgo/extensions/sse"
+)
+
+// Message types, mirroring the relevant subset of
+// github.com/gorilla/websocket's constants, so this package doesn't need to
+// depend on the gorilla client directly
+const (
+	textMessage = 1
+	pingMessage = 9
+)
+
+const (
+	// WriteWait is the time allowed to write a message to a peer
+	WriteWait = 10 * time.Second
+	// PongWait is the time allowed to read the next pong message from a peer
+	PongWait = 60 * time.Second
+	// PingPeriod is how often pings are sent; must be less than PongWait
+	PingPeriod = (PongWait * 9) / 10
+	// MaxMessageSize is the maximum message size allowed from a peer
+	MaxMessageSize int64 = 512 * 1024
+)
+
+// Conn is the subset of *websocket.Conn (github.com/gorilla/websocket) that
+// this package needs. Accepting an interface instead of the concrete type
+// keeps this package free of a hard dependency on the gorilla client
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+// transport is the sse.Transport used by WebSocket clients, writing
+// messages as JSON text frames
+type transport struct {
+	conn Conn
+}
+
+// NewTransport returns the sse.Transport used to stream messages over conn.
+// Pass it to the same sse.ClientManager used for SSE clients so both
+// transports share one room/topic subsystem
+func NewTransport(conn Conn) sse.Transport {
+	return &transport{conn: conn}
+}
+
+func (t *transport) Send(msg *sse.Message) error {
+	if msg.IsPing() {
+		return t.Ping()
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := t.conn.SetWriteDeadline(time.Now().Add(WriteWait)); err != nil {
+		return err
+	}
+
+	return t.conn.WriteMessage(textMessage, data)
+}
+
+// Ping writes a WebSocket ping control frame
+func (t *transport) Ping() error {
+	return t.conn.WriteControl(pingMessage, nil, time.Now().Add(WriteWait))
+}
+
+func (t *transport) Close() error {
+	return t.conn.Close()
+}